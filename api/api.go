@@ -0,0 +1,221 @@
+// Package api exposes a Store's tuple records over HTTP as newline-delimited
+// JSON, using the field names and endpoint shapes shared by the CIRCL and
+// Farsight passive DNS APIs, so bro-pdns can be queried by existing pDNS
+// clients and threat-intel tooling.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bro-pdns/bro-pdns/store"
+)
+
+// Record is a single rrset observation in the CIRCL/Farsight passive DNS
+// JSON shape.
+type Record struct {
+	RRName    string `json:"rrname"`
+	RRType    string `json:"rrtype"`
+	RData     string `json:"rdata"`
+	TimeFirst int64  `json:"time_first"`
+	TimeLast  int64  `json:"time_last"`
+	Count     uint64 `json:"count"`
+	SensorID  string `json:"sensor_id,omitempty"`
+}
+
+// Options configures a Server's optional bearer-token authentication and
+// per-token rate limiting. A zero Options value disables both: every
+// request is served unauthenticated with no rate limit.
+type Options struct {
+	// Tokens is the set of bearer tokens allowed to query the API. If
+	// empty, authentication is disabled and every request is accepted.
+	Tokens map[string]bool
+	// RateLimit is the sustained requests-per-second allowed per token. A
+	// zero value disables rate limiting even if Tokens is set.
+	RateLimit float64
+	// RateBurst is the maximum burst size for the token-bucket limiter.
+	// Defaults to 1 if RateLimit is set and RateBurst is zero.
+	RateBurst int
+}
+
+// Server serves the passive DNS query API described in package api's doc
+// comment on top of a store.Store.
+type Server struct {
+	store store.Store
+	opts  Options
+	mux   *http.ServeMux
+
+	limitersMu sync.Mutex
+	limiters   map[string]*limiter
+}
+
+// NewServer returns an http.Handler serving /query/, /rrset/ and /rdata/
+// against s.
+func NewServer(s store.Store, opts Options) *Server {
+	if opts.RateLimit > 0 && opts.RateBurst == 0 {
+		opts.RateBurst = 1
+	}
+	srv := &Server{
+		store:    s,
+		opts:     opts,
+		limiters: map[string]*limiter{},
+	}
+	srv.mux = http.NewServeMux()
+	srv.mux.HandleFunc("/query/", srv.handleQuery)
+	srv.mux.HandleFunc("/rrset/", srv.handleRRSet)
+	srv.mux.HandleFunc("/rdata/", srv.handleRData)
+	return srv
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := srv.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if token != "" && srv.opts.RateLimit > 0 && !srv.limiterFor(token).allow() {
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	srv.mux.ServeHTTP(w, r)
+}
+
+// authenticate reports whether r may proceed, and the bearer token it
+// presented (empty if auth is disabled). Requests are rejected only when
+// Tokens is non-empty and the request's token isn't in it.
+func (srv *Server) authenticate(r *http.Request) (token string, ok bool) {
+	if len(srv.opts.Tokens) == 0 {
+		return "", true
+	}
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, prefix) {
+		return "", false
+	}
+	token = strings.TrimPrefix(h, prefix)
+	return token, srv.opts.Tokens[token]
+}
+
+func (srv *Server) limiterFor(token string) *limiter {
+	srv.limitersMu.Lock()
+	defer srv.limitersMu.Unlock()
+	l, ok := srv.limiters[token]
+	if !ok {
+		l = &limiter{rate: srv.opts.RateLimit, burst: float64(srv.opts.RateBurst), tokens: float64(srv.opts.RateBurst), last: time.Now()}
+		srv.limiters[token] = l
+	}
+	return l
+}
+
+// handleQuery serves /query/{name-or-ip}, matching rrname or rdata exactly
+// like store.Store.FindTuples.
+func (srv *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/query/")
+	if name == "" {
+		http.Error(w, "missing name", http.StatusBadRequest)
+		return
+	}
+	tr, err := srv.store.FindTuples(name, r.URL.Query().Get("sensor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeRecords(w, tr, "")
+}
+
+// handleRRSet serves /rrset/{rrname}[/{rrtype}]: the rrset owned by rrname,
+// the forward ("what does this name resolve to") direction.
+func (srv *Server) handleRRSet(w http.ResponseWriter, r *http.Request) {
+	rrname, rrtype := splitNameType(strings.TrimPrefix(r.URL.Path, "/rrset/"))
+	if rrname == "" {
+		http.Error(w, "missing rrname", http.StatusBadRequest)
+		return
+	}
+	tr, err := srv.store.FindQueryTuples(rrname, r.URL.Query().Get("sensor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeRecords(w, tr, rrtype)
+}
+
+// handleRData serves /rdata/{name-or-ip}[/{rrtype}]: the reverse ("what
+// names resolve to this value") direction. store.Store has no answer-only
+// lookup, so this reuses FindTuples (which matches rrname or rdata) and
+// relies on callers filtering by rrtype/rdata client-side if they also
+// queried a popular rrname.
+func (srv *Server) handleRData(w http.ResponseWriter, r *http.Request) {
+	name, rrtype := splitNameType(strings.TrimPrefix(r.URL.Path, "/rdata/"))
+	if name == "" {
+		http.Error(w, "missing name", http.StatusBadRequest)
+		return
+	}
+	tr, err := srv.store.FindTuples(name, r.URL.Query().Get("sensor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeRecords(w, tr, rrtype)
+}
+
+func splitNameType(path string) (name, rrtype string) {
+	parts := strings.SplitN(path, "/", 2)
+	name = parts[0]
+	if len(parts) == 2 {
+		rrtype = parts[1]
+	}
+	return name, rrtype
+}
+
+// writeRecords writes tr as newline-delimited JSON Records, one per line,
+// skipping any whose Type doesn't match rrtype when rrtype is non-empty.
+func writeRecords(w http.ResponseWriter, tr store.TupleResults, rrtype string) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, t := range tr {
+		if rrtype != "" && t.Type != rrtype {
+			continue
+		}
+		enc.Encode(toRecord(t))
+	}
+}
+
+func toRecord(t store.TupleResult) Record {
+	return Record{
+		RRName:    t.Query,
+		RRType:    t.Type,
+		RData:     t.Answer,
+		TimeFirst: t.First.Unix(),
+		TimeLast:  t.Last.Unix(),
+		Count:     t.Count,
+		SensorID:  t.Sensor,
+	}
+}
+
+// limiter is a minimal token-bucket rate limiter.
+type limiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  float64
+}
+
+func (l *limiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
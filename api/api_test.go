@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bro-pdns/bro-pdns/store"
+	_ "github.com/bro-pdns/bro-pdns/store/sqlite"
+)
+
+func newTestStore(t *testing.T) store.Store {
+	t.Helper()
+	s, err := store.NewStore("sqlite://file::memory:?cache=shared")
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	store.MigrateUp(t, s)
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return s
+}
+
+// TestQueryShape verifies /query/ returns the exact CIRCL/Farsight JSON
+// shape, byte for byte, for a single known record.
+func TestQueryShape(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	first := time.Unix(1700000000, 0).UTC()
+	last := time.Unix(1700003600, 0).UTC()
+	ar := store.AggregationResult{
+		Tuples: []store.TupleRecord{
+			{Query: "www.example.com", Type: "A", Answer: "1.2.3.4", TTL: 300, First: first, Last: last, Count: 3, Sensor: "sensor-a"},
+		},
+	}
+	if _, err := s.Update(ar); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	srv := NewServer(s, Options{})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/query/www.example.com")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	const wantBody = `{"rrname":"www.example.com","rrtype":"A","rdata":"1.2.3.4","time_first":1700000000,"time_last":1700003600,"count":3,"sensor_id":"sensor-a"}` + "\n"
+	if string(body) != wantBody {
+		t.Fatalf("got body %q, want %q", body, wantBody)
+	}
+
+	var rec Record
+	if err := json.Unmarshal(body, &rec); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	want := Record{
+		RRName:    "www.example.com",
+		RRType:    "A",
+		RData:     "1.2.3.4",
+		TimeFirst: 1700000000,
+		TimeLast:  1700003600,
+		Count:     3,
+		SensorID:  "sensor-a",
+	}
+	if rec != want {
+		t.Fatalf("got %+v, want %+v", rec, want)
+	}
+}
+
+// TestRRSetFilterByType verifies /rrset/{rrname}/{rrtype} filters on rrtype.
+func TestRRSetFilterByType(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	ar := store.AggregationResult{
+		Tuples: []store.TupleRecord{
+			{Query: "www.example.com", Type: "A", Answer: "1.2.3.4", First: now, Last: now, Count: 1},
+			{Query: "www.example.com", Type: "AAAA", Answer: "::1", First: now, Last: now, Count: 1},
+		},
+	}
+	if _, err := s.Update(ar); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	srv := NewServer(s, Options{})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/rrset/www.example.com/AAAA")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var recs []Record
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); err != nil {
+			break
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 1 || recs[0].RRType != "AAAA" {
+		t.Fatalf("expected one AAAA record, got %+v", recs)
+	}
+}
+
+// TestAuthRejectsMissingToken verifies requests are rejected when Options
+// configures bearer tokens and none is presented.
+func TestAuthRejectsMissingToken(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	srv := NewServer(s, Options{Tokens: map[string]bool{"secret": true}})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/query/www.example.com")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", resp.StatusCode)
+	}
+}
+
+// TestRateLimitAllowsBurst verifies a freshly created limiter starts with
+// its full burst allowance rather than rejecting a new token's opening
+// requests.
+func TestRateLimitAllowsBurst(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	srv := NewServer(s, Options{
+		Tokens:    map[string]bool{"secret": true},
+		RateLimit: 100,
+		RateBurst: 5,
+	})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	get := func() int {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/query/www.example.com", nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for i := 0; i < 5; i++ {
+		if code := get(); code != http.StatusOK {
+			t.Fatalf("request %d: expected 200 within burst, got %d", i, code)
+		}
+	}
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Fatalf("request past burst: expected 429, got %d", code)
+	}
+}
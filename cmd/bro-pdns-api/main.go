@@ -0,0 +1,51 @@
+// Command bro-pdns-api serves the passive-DNS query API (see package api)
+// against a registered store.Store backend:
+//
+//	bro-pdns-api --store clickhouse://localhost:9000 --listen :8080
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/bro-pdns/bro-pdns/api"
+	"github.com/bro-pdns/bro-pdns/store"
+	_ "github.com/bro-pdns/bro-pdns/store/clickhouse"
+	_ "github.com/bro-pdns/bro-pdns/store/postgres"
+	_ "github.com/bro-pdns/bro-pdns/store/sqlite"
+)
+
+func main() {
+	uri := flag.String("store", "", "store URI, e.g. sqlite:///var/lib/bro-pdns/store.db")
+	listen := flag.String("listen", ":8080", "address to listen on")
+	tokens := flag.String("tokens", "", "comma-separated bearer tokens required to query the API; empty disables auth")
+	rateLimit := flag.Float64("rate-limit", 0, "sustained requests/sec allowed per token; 0 disables rate limiting")
+	rateBurst := flag.Int("rate-burst", 5, "burst size for -rate-limit")
+	flag.Parse()
+
+	if *uri == "" {
+		log.Fatal("usage: bro-pdns-api --store <uri> [--listen :8080]")
+	}
+
+	s, err := store.NewStore(*uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Init(); err != nil {
+		log.Fatal(err)
+	}
+
+	opts := api.Options{RateLimit: *rateLimit, RateBurst: *rateBurst}
+	if *tokens != "" {
+		opts.Tokens = map[string]bool{}
+		for _, tok := range strings.Split(*tokens, ",") {
+			opts.Tokens[tok] = true
+		}
+	}
+
+	log.Printf("bro-pdns-api listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, api.NewServer(s, opts)))
+}
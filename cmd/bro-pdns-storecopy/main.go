@@ -0,0 +1,84 @@
+// Command bro-pdns-storecopy copies every tuple and individual record from
+// one registered store.Store backend to another, e.g. to migrate an
+// existing ClickHouse deployment onto Postgres:
+//
+//	bro-pdns-storecopy clickhouse://localhost:9000 postgres://localhost/bro_pdns?sslmode=disable
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/bro-pdns/bro-pdns/store"
+	_ "github.com/bro-pdns/bro-pdns/store/clickhouse"
+	_ "github.com/bro-pdns/bro-pdns/store/postgres"
+	_ "github.com/bro-pdns/bro-pdns/store/sqlite"
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 {
+		log.Fatalf("usage: %s <src-uri> <dst-uri>", flag.CommandLine.Name())
+	}
+	src, dst := flag.Arg(0), flag.Arg(1)
+
+	if err := copyStore(src, dst); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func copyStore(srcURI, dstURI string) error {
+	srcStore, err := store.NewStore(srcURI)
+	if err != nil {
+		return err
+	}
+	defer srcStore.Close()
+
+	dumper, ok := srcStore.(store.Dumper)
+	if !ok {
+		return fmt.Errorf("%s does not support dumping its contents", srcURI)
+	}
+
+	dstStore, err := store.NewStore(dstURI)
+	if err != nil {
+		return err
+	}
+	defer dstStore.Close()
+	if err := dstStore.Init(); err != nil {
+		if m, ok := dstStore.(store.Migrator); ok {
+			log.Printf("destination schema out of date (%v), applying pending migrations", err)
+			if err := applyMigrations(m); err != nil {
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+
+	ar, err := dumper.Dump()
+	if err != nil {
+		return err
+	}
+	log.Printf("copying %d tuples and %d individual records from %s to %s", len(ar.Tuples), len(ar.Individual), srcURI, dstURI)
+
+	ur, err := dstStore.Update(ar)
+	if err != nil {
+		return err
+	}
+	log.Printf("copy complete: %d inserted, %d updated, took %s", ur.Inserted, ur.Updated, ur.Duration)
+	return nil
+}
+
+func applyMigrations(m store.Migrator) error {
+	pending, err := m.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	for _, mig := range pending {
+		if err := m.ApplyMigration(mig, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
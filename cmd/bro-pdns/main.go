@@ -0,0 +1,118 @@
+// Command bro-pdns is the bro-pdns CLI. Today it implements the "migrate"
+// subcommand for managing a store's schema; aggregation and serving live in
+// their own entry points.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bro-pdns/bro-pdns/store"
+	_ "github.com/bro-pdns/bro-pdns/store/clickhouse"
+	_ "github.com/bro-pdns/bro-pdns/store/postgres"
+	_ "github.com/bro-pdns/bro-pdns/store/sqlite"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "migrate":
+		migrateMain(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bro-pdns migrate up|down|status --store <uri>")
+	os.Exit(1)
+}
+
+func migrateMain(args []string) {
+	if len(args) < 1 {
+		usage()
+	}
+	cmd := args[0]
+
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	uri := fs.String("store", "", "store URI, e.g. sqlite:///var/lib/bro-pdns/store.db")
+	fs.Parse(args[1:])
+	if *uri == "" || fs.NArg() != 0 {
+		usage()
+	}
+
+	s, err := store.NewStore(*uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	m, ok := s.(store.Migrator)
+	if !ok {
+		log.Fatalf("%s does not support migrations", *uri)
+	}
+
+	switch cmd {
+	case "status":
+		migrateStatus(m)
+	case "up":
+		migrateUp(m)
+	case "down":
+		migrateDown(m)
+	default:
+		usage()
+	}
+}
+
+func migrateStatus(m store.Migrator) {
+	pending, err := m.PendingMigrations()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(pending) == 0 {
+		fmt.Println("up to date")
+		return
+	}
+	for _, mig := range pending {
+		fmt.Printf("pending: %d %s\n", mig.Version, mig.Name)
+	}
+}
+
+func migrateUp(m store.Migrator) {
+	pending, err := m.PendingMigrations()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, mig := range pending {
+		fmt.Printf("applying %d %s\n", mig.Version, mig.Name)
+		if err := m.ApplyMigration(mig, false); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func migrateDown(m store.Migrator) {
+	applied, err := m.AppliedMigrations()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(applied) == 0 {
+		fmt.Println("nothing to roll back")
+		return
+	}
+	last := applied[len(applied)-1]
+	for _, mig := range store.Migrations() {
+		if mig.Version == last {
+			fmt.Printf("rolling back %d %s\n", mig.Version, mig.Name)
+			if err := m.ApplyMigration(mig, true); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	log.Fatalf("no migration registered for applied version %d", last)
+}
@@ -0,0 +1,599 @@
+// Package clickhouse implements store.Store on top of ClickHouse, and
+// registers itself under the "clickhouse" URI scheme.
+package clickhouse
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/kshvakov/clickhouse"
+	"github.com/pkg/errors"
+	chttp "github.com/roistat/go-clickhouse"
+
+	"github.com/bro-pdns/bro-pdns/store"
+)
+
+const scheme = "clickhouse"
+
+func init() {
+	store.Register(scheme, New)
+	store.RegisterMigration(1, "initial schema", scheme, schemaV1Up, schemaV1Down)
+	store.RegisterMigration(2, "add sensor column", scheme, schemaV2Up, schemaV2Down)
+}
+
+const migrationsSchema = `
+CREATE TABLE IF NOT EXISTS migrations (
+    version UInt32,
+    name String,
+    applied_at DateTime DEFAULT now()
+  ) ENGINE = MergeTree(applied_at, (version), 8192);
+`
+
+var schemaV1Up = []string{
+	`
+CREATE TABLE IF NOT EXISTS tuples (
+    whatever Date DEFAULT '2000-01-01',
+    query String,
+    type String,
+    answer String,
+    ttl AggregateFunction(anyLast, UInt16),
+    first AggregateFunction(min, DateTime),
+    last AggregateFunction(max, DateTime),
+    count AggregateFunction(sum, UInt64)
+  ) ENGINE = AggregatingMergeTree(whatever, (query, type, answer), 8192);
+`,
+
+	`
+CREATE TABLE IF NOT EXISTS individual (
+    whatever Date DEFAULT '2000-01-01',
+    which Enum8('Q'=0, 'A'=1),
+    value String,
+    first AggregateFunction(min, DateTime),
+    last AggregateFunction(max, DateTime),
+    count AggregateFunction(sum, UInt64)
+  ) ENGINE = AggregatingMergeTree(whatever, (which, value), 8192);
+`,
+	`
+CREATE TABLE IF NOT EXISTS filenames (
+	day Date DEFAULT toDate(ts),
+	ts DateTime DEFAULT now(),
+	filename String,
+	aggregation_time Float64,
+	total_records UInt64,
+	skipped_records UInt64,
+	tuples UInt64,
+	individual UInt64,
+	store_time Float64,
+	inserted UInt64,
+	updated UInt64
+  ) ENGINE = MergeTree(day, (filename), 8192);
+`}
+
+var schemaV1Down = []string{
+	"DROP TABLE IF EXISTS tuples",
+	"DROP TABLE IF EXISTS individual",
+	"DROP TABLE IF EXISTS filenames",
+}
+
+// schemaV2 adds a sensor tag to every tuple/individual observation, so
+// deployments ingesting from many sensors can tell which one saw a given
+// record. sensor is a plain column rather than an aggregate state, grouped
+// on alongside query/type/answer, so the same tuple seen by two sensors
+// stores as two rows. Note that ClickHouse can't ALTER a MergeTree's
+// existing ORDER BY/sort key in place; a deployment that wants sensor
+// folded into the physical sort key (not just filterable) still needs a
+// one-time table recreation outside this migration.
+var schemaV2Up = []string{
+	"ALTER TABLE tuples ADD COLUMN IF NOT EXISTS sensor LowCardinality(String) DEFAULT ''",
+	"ALTER TABLE individual ADD COLUMN IF NOT EXISTS sensor LowCardinality(String) DEFAULT ''",
+}
+
+var schemaV2Down = []string{
+	"ALTER TABLE tuples DROP COLUMN IF EXISTS sensor",
+	"ALTER TABLE individual DROP COLUMN IF EXISTS sensor",
+}
+
+const tuplesTempStmt = `
+CREATE TABLE tuples_temp (
+    query String,
+    type String,
+    answer String,
+    ttl UInt16,
+    first DateTime,
+    last DateTime,
+    count UInt64,
+    sensor LowCardinality(String)
+) ENGINE = Log`
+
+const individualTempStmt = `
+CREATE TABLE individual_temp (
+    which Enum8('Q'=0, 'A'=1),
+    value String,
+    first DateTime,
+    last DateTime,
+    count UInt64,
+    sensor LowCardinality(String)
+) ENGINE = Log`
+
+// Store is a store.Store backed by ClickHouse.
+type Store struct {
+	conn *sqlx.DB
+	http *chttp.Conn
+	host string
+}
+
+// New opens a ClickHouse-backed store.Store for the given URI, e.g.
+// "clickhouse://host:9000".
+func New(uri string) (store.Store, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := sqlx.Open("clickhouse", uri)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	t := chttp.NewHttpTransport()
+	h := chttp.NewConn(fmt.Sprintf("%s:8123/default", u.Hostname()), t)
+	return &Store{
+		conn: conn,
+		http: h,
+		host: u.Hostname(),
+	}, nil
+}
+
+func (s *Store) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Store) exec(query string) error {
+	q := chttp.NewQuery(query)
+	return q.Exec(s.http)
+}
+
+// Init verifies the schema is up to date. It does not apply schema changes
+// itself: run `bro-pdns migrate up` first if it reports pending migrations.
+func (s *Store) Init() error {
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("clickhouse: %d pending migration(s), run `bro-pdns migrate up`", len(pending))
+	}
+	return nil
+}
+
+func (s *Store) ensureMigrationsTable() error {
+	return s.exec(migrationsSchema)
+}
+
+func (s *Store) AppliedMigrations() ([]int, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	var versions []int
+	err := s.conn.Select(&versions, "SELECT version FROM migrations ORDER BY version")
+	return versions, err
+}
+
+func (s *Store) PendingMigrations() ([]store.Migration, error) {
+	applied, err := s.AppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return store.PendingMigrations(scheme, applied), nil
+}
+
+func (s *Store) ApplyMigration(m store.Migration, down bool) error {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return err
+	}
+	stmts := m.Up[scheme]
+	if down {
+		stmts = m.Down[scheme]
+	}
+	for _, stmt := range stmts {
+		if err := s.exec(stmt); err != nil {
+			return errors.Wrapf(err, "clickhouse: migration %d (%s) failed", m.Version, m.Name)
+		}
+	}
+	if down {
+		return s.exec(fmt.Sprintf("ALTER TABLE migrations DELETE WHERE version = %d", m.Version))
+	}
+	return s.exec(fmt.Sprintf("INSERT INTO migrations (version, name) VALUES (%d, '%s')", m.Version, m.Name))
+}
+
+func (s *Store) Clear() error {
+	stmts := []string{"DELETE FROM filenames", "DELETE FROM individual", "DELETE FROM tuples"}
+	for _, stmt := range stmts {
+		if err := s.exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Begin() error {
+	return fmt.Errorf("clickhouse doesn't support transactions")
+}
+func (s *Store) Commit() error {
+	log.Printf("clickhouse doesn't support transactions")
+	return nil
+}
+
+// DeleteOld deletes records that haven't been seen in days, returns the
+// total records deleted.
+func (s *Store) DeleteOld(days int64) (int64, error) {
+	return 0, fmt.Errorf("clickhouse doesn't support delete")
+}
+
+// sendJSON bulk-loads r into table over ClickHouse's HTTP interface using
+// FORMAT JSONEachRow. It predates bulkInsertTuples/bulkInsertIndividual and
+// is kept only as a slower reference path for the benchmarks in
+// clickhouse_bench_test.go: a single HTTP POST with no batching, no worker
+// pool and no retries, so it falls over on large batches or flaky
+// connections in a way the native path doesn't.
+func (s *Store) sendJSON(table string, r io.Reader) error {
+	client := http.Client{Timeout: 60 * time.Second}
+
+	v := url.Values{}
+	v.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", table))
+	u := fmt.Sprintf("http://%s:8123?%s", s.host, v.Encode())
+
+	resp, err := client.Post(u, "application/json", r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("clickhouse error: %s", body)
+	}
+	return err
+}
+
+// bulkInsertOpts controls how bulkInsertTuples/bulkInsertIndividual split
+// rows into transactions and spread them across concurrent connections.
+type bulkInsertOpts struct {
+	BatchSize int
+	Workers   int
+}
+
+var defaultBulkInsertOpts = bulkInsertOpts{BatchSize: 50000, Workers: 4}
+
+// runBatches partitions the half-open range [0, n) into opts.BatchSize-sized
+// chunks and runs insertBatch(lo, hi) for each across a bounded pool of
+// opts.Workers goroutines, returning the first error encountered.
+func runBatches(n int, opts bulkInsertOpts, insertBatch func(lo, hi int) error) error {
+	if n == 0 {
+		return nil
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = n
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	type batch struct{ lo, hi int }
+	batches := make(chan batch, (n+batchSize-1)/batchSize)
+	for lo := 0; lo < n; lo += batchSize {
+		hi := lo + batchSize
+		if hi > n {
+			hi = n
+		}
+		batches <- batch{lo, hi}
+	}
+	close(batches)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				if err := insertBatch(b.lo, b.hi); err != nil {
+					errOnce.Do(func() { firstErr = err })
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// withRetry retries fn with exponential backoff, up to 5 attempts, but only
+// for errors that look like transient connection problems rather than bad
+// data or a broken query.
+func withRetry(fn func() error) error {
+	const maxAttempts = 5
+	backoff := 100 * time.Millisecond
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if !isTransient(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return err
+}
+
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == driver.ErrBadConn {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return false
+}
+
+// bulkInsertTuples loads tuples into tableName using the native ClickHouse
+// protocol: each batch is its own transaction with a single prepared
+// INSERT, so the driver buffers rows client-side and ships them to the
+// server as one block on commit.
+func (s *Store) bulkInsertTuples(tableName string, rows []store.TupleRecord, reverse bool, defaultSensor string, opts bulkInsertOpts) error {
+	return runBatches(len(rows), opts, func(lo, hi int) error {
+		return withRetry(func() error {
+			tx, err := s.conn.Begin()
+			if err != nil {
+				return err
+			}
+			stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (query, type, answer, ttl, first, last, count, sensor) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", tableName))
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			defer stmt.Close()
+			for _, t := range rows[lo:hi] {
+				query := t.Query
+				if reverse {
+					query = store.Reverse(query)
+				}
+				sensor := t.Sensor
+				if sensor == "" {
+					sensor = defaultSensor
+				}
+				if _, err := stmt.Exec(query, t.Type, t.Answer, t.TTL, t.First, t.Last, t.Count, sensor); err != nil {
+					tx.Rollback()
+					return err
+				}
+			}
+			return tx.Commit()
+		})
+	})
+}
+
+// bulkInsertIndividual is bulkInsertTuples for individual records.
+func (s *Store) bulkInsertIndividual(tableName string, rows []store.IndividualRecord, reverse bool, defaultSensor string, opts bulkInsertOpts) error {
+	return runBatches(len(rows), opts, func(lo, hi int) error {
+		return withRetry(func() error {
+			tx, err := s.conn.Begin()
+			if err != nil {
+				return err
+			}
+			stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (which, value, first, last, count, sensor) VALUES (?, ?, ?, ?, ?, ?)", tableName))
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			defer stmt.Close()
+			for _, v := range rows[lo:hi] {
+				value := v.Value
+				if reverse && v.Which == "Q" {
+					value = store.Reverse(value)
+				}
+				sensor := v.Sensor
+				if sensor == "" {
+					sensor = defaultSensor
+				}
+				if _, err := stmt.Exec(v.Which, value, v.First, v.Last, v.Count, sensor); err != nil {
+					tx.Rollback()
+					return err
+				}
+			}
+			return tx.Commit()
+		})
+	})
+}
+
+func (s *Store) Update(ar store.AggregationResult) (store.UpdateResult, error) {
+	var result store.UpdateResult
+	start := time.Now()
+
+	s.exec("DROP TABLE tuples_temp")
+	s.exec("DROP TABLE individual_temp")
+
+	err := s.exec(tuplesTempStmt)
+	if err != nil {
+		return result, errors.Wrap(err, "clickhouse: failed to create temporary tuples table")
+	}
+	err = s.exec(individualTempStmt)
+	if err != nil {
+		return result, errors.Wrap(err, "clickhouse: failed to create temporary individual table")
+	}
+	defer func() {
+		//s.exec("DROP TABLE tuples_temp")
+		//s.exec("DROP TABLE individual_temp")
+	}()
+
+	err = s.bulkInsertTuples("tuples_temp", ar.Tuples, true, ar.Sensor, defaultBulkInsertOpts)
+	if err != nil {
+		return result, errors.Wrap(err, "clickhouse: tuples update failed")
+	}
+
+	err = s.exec(`INSERT INTO tuples (query, type, answer, sensor, ttl, first, last, count) SELECT query, type, answer, sensor, anyLastState(ttl), minState(first), maxState(last), sumState(count) from tuples_temp group by query, type, answer, sensor`)
+	if err != nil {
+		return result, errors.Wrap(err, "clickhouse: failed to insert into tuples")
+	}
+
+	err = s.bulkInsertIndividual("individual_temp", ar.Individual, true, ar.Sensor, defaultBulkInsertOpts)
+	if err != nil {
+		return result, errors.Wrap(err, "clickhouse: individual update failed")
+	}
+
+	err = s.exec(`INSERT INTO individual (which, value, sensor, first, last, count) SELECT which, value, sensor, minState(first), maxState(last), sumState(count) from individual_temp group by which, value, sensor`)
+	if err != nil {
+		return result, errors.Wrap(err, "clickhouse: failed to insert into individual")
+	}
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+func (s *Store) IsLogIndexed(filename string) (bool, error) {
+	var fn string
+	err := s.conn.QueryRow("SELECT filename FROM filenames WHERE filename=?", filename).Scan(&fn)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+func (s *Store) SetLogIndexed(filename string, ar store.AggregationResult, ur store.UpdateResult) error {
+	tx, _ := s.conn.Begin()
+	q := `INSERT INTO filenames (filename,
+	      aggregation_time, total_records, skipped_records, tuples, individual,
+	      store_time, inserted, updated)
+	      VALUES (?,?,?,?,?,?,?,?,?)`
+	_, err := tx.Exec(q, filename,
+		ar.Duration.Seconds(), uint64(ar.TotalRecords), uint64(ar.SkippedRecords), len(ar.Tuples), len(ar.Individual),
+		ur.Duration.Seconds(), uint64(ur.Inserted), uint64(ur.Updated))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) FindQueryTuples(query, sensor string) (store.TupleResults, error) {
+	tr := store.TupleResults{}
+	rquery := store.Reverse(query)
+	err := s.conn.Select(&tr, "SELECT query, type, answer, sensor, minMerge(first) as first, maxMerge(last) as last, sumMerge(count) as count from tuples WHERE query = ? AND (? = '' OR sensor = ?) group by query, type, answer, sensor ORDER BY query, answer", rquery, sensor, sensor)
+	store.ReverseTuples(tr)
+	return tr, err
+}
+
+func (s *Store) FindTuples(query, sensor string) (store.TupleResults, error) {
+	tr := store.TupleResults{}
+	rquery := store.Reverse(query)
+	err := s.conn.Select(&tr, "SELECT query, type, answer, sensor, minMerge(first) as first, maxMerge(last) as last, sumMerge(count) as count from tuples WHERE (query = ? OR answer = ?) AND (? = '' OR sensor = ?) group by query, type, answer, sensor ORDER BY query, answer", rquery, query, sensor, sensor)
+	store.ReverseTuples(tr)
+	return tr, err
+}
+
+func (s *Store) LikeTuples(query, sensor string) (store.TupleResults, error) {
+	tr := store.TupleResults{}
+	rquery := store.Reverse(query)
+	err := s.conn.Select(&tr, "SELECT query, type, answer, sensor, minMerge(first) as first, maxMerge(last) as last, sumMerge(count) as count from tuples WHERE (query like ? OR answer like ?) AND (? = '' OR sensor = ?) group by query, type, answer, sensor ORDER BY query, answer", rquery+"%", query+"%", sensor, sensor)
+	store.ReverseTuples(tr)
+	return tr, err
+}
+
+func (s *Store) FindIndividual(value, sensor string) (store.IndividualResults, error) {
+	rvalue := store.Reverse(value)
+	tr := store.IndividualResults{}
+	err := s.conn.Select(&tr, `SELECT which, value, sensor, minMerge(first) as first, maxMerge(last) as last, sumMerge(count) as count from individual WHERE ((which='A' AND value = ?) OR (which='Q' AND value = ?)) AND (? = '' OR sensor = ?) group by which, value, sensor ORDER BY value`, value, rvalue, sensor, sensor)
+	store.ReverseIndividual(tr)
+	return tr, err
+}
+
+func (s *Store) LikeIndividual(value, sensor string) (store.IndividualResults, error) {
+	rvalue := store.Reverse(value)
+	tr := store.IndividualResults{}
+	err := s.conn.Select(&tr, `SELECT which, value, sensor, minMerge(first) as first, maxMerge(last) as last, sumMerge(count) as count from individual WHERE ((which='A' AND value like ?) OR (which='Q' AND value like ?)) AND (? = '' OR sensor = ?) group by which, value, sensor ORDER BY value`, value+"%", rvalue+"%", sensor, sensor)
+	store.ReverseIndividual(tr)
+	return tr, err
+}
+
+// tupleDumpRow is tuples merged back into plain values for Dump, including
+// ttl, which the Find/Like queries never need to return.
+type tupleDumpRow struct {
+	Query  string    `db:"query"`
+	Type   string    `db:"type"`
+	Answer string    `db:"answer"`
+	Sensor string    `db:"sensor"`
+	TTL    uint16    `db:"ttl"`
+	First  time.Time `db:"first"`
+	Last   time.Time `db:"last"`
+	Count  uint64    `db:"count"`
+}
+
+// Dump reads every stored tuple and individual record back out as an
+// AggregationResult, for migrating data into another backend (see
+// cmd/bro-pdns-storecopy).
+func (s *Store) Dump() (store.AggregationResult, error) {
+	var ar store.AggregationResult
+
+	var trows []tupleDumpRow
+	err := s.conn.Select(&trows, "SELECT query, type, answer, sensor, anyLastMerge(ttl) as ttl, minMerge(first) as first, maxMerge(last) as last, sumMerge(count) as count from tuples group by query, type, answer, sensor")
+	if err != nil {
+		return ar, err
+	}
+	for _, t := range trows {
+		ar.Tuples = append(ar.Tuples, store.TupleRecord{
+			Query:  store.Reverse(t.Query),
+			Type:   t.Type,
+			Answer: t.Answer,
+			TTL:    t.TTL,
+			First:  t.First,
+			Last:   t.Last,
+			Count:  t.Count,
+			Sensor: t.Sensor,
+		})
+	}
+
+	ir := store.IndividualResults{}
+	err = s.conn.Select(&ir, "SELECT which, value, sensor, minMerge(first) as first, maxMerge(last) as last, sumMerge(count) as count from individual group by which, value, sensor")
+	if err != nil {
+		return ar, err
+	}
+	for _, v := range ir {
+		value := v.Value
+		if v.Which == "Q" {
+			value = store.Reverse(value)
+		}
+		ar.Individual = append(ar.Individual, store.IndividualRecord{
+			Which:  v.Which,
+			Value:  value,
+			First:  v.First,
+			Last:   v.Last,
+			Count:  v.Count,
+			Sensor: v.Sensor,
+		})
+	}
+	return ar, nil
+}
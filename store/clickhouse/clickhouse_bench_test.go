@@ -0,0 +1,78 @@
+package clickhouse
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bro-pdns/bro-pdns/store"
+)
+
+// benchTuples builds n synthetic tuple records for the bulk-insert
+// benchmarks below.
+func benchTuples(n int) []store.TupleRecord {
+	now := time.Now().UTC().Truncate(time.Second)
+	rows := make([]store.TupleRecord, n)
+	for i := range rows {
+		rows[i] = store.TupleRecord{
+			Query:  "www.example.com",
+			Type:   "A",
+			Answer: "1.2.3.4",
+			TTL:    300,
+			First:  now,
+			Last:   now,
+			Count:  1,
+			Sensor: "bench",
+		}
+	}
+	return rows
+}
+
+// benchStore requires a running ClickHouse instance; point
+// BRO_PDNS_TEST_CLICKHOUSE_URI at it to run these benchmarks.
+func benchStore(b *testing.B) *Store {
+	b.Helper()
+	uri := os.Getenv("BRO_PDNS_TEST_CLICKHOUSE_URI")
+	if uri == "" {
+		b.Skip("BRO_PDNS_TEST_CLICKHOUSE_URI not set, skipping")
+	}
+	st, err := New(uri)
+	if err != nil {
+		b.Fatalf("New: %v", err)
+	}
+	s := st.(*Store)
+	s.exec("DROP TABLE IF EXISTS tuples_temp")
+	if err := s.exec(tuplesTempStmt); err != nil {
+		b.Fatalf("create tuples_temp: %v", err)
+	}
+	return s
+}
+
+// BenchmarkSendJSON exercises the legacy HTTP JSONEachRow ingest path.
+func BenchmarkSendJSON(b *testing.B) {
+	s := benchStore(b)
+	defer s.Close()
+	rows := benchTuples(10000)
+	ar := store.AggregationResult{Tuples: rows}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.sendJSON("tuples_temp", ar.TupleJSONReader(true)); err != nil {
+			b.Fatalf("sendJSON: %v", err)
+		}
+	}
+}
+
+// BenchmarkBulkInsertTuples exercises the native-protocol bulk insert path.
+func BenchmarkBulkInsertTuples(b *testing.B) {
+	s := benchStore(b)
+	defer s.Close()
+	rows := benchTuples(10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.bulkInsertTuples("tuples_temp", rows, true, "", defaultBulkInsertOpts); err != nil {
+			b.Fatalf("bulkInsertTuples: %v", err)
+		}
+	}
+}
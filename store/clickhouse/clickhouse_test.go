@@ -0,0 +1,30 @@
+package clickhouse
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bro-pdns/bro-pdns/store"
+)
+
+// TestConformance requires a running ClickHouse instance; point
+// BRO_PDNS_TEST_CLICKHOUSE_URI at it to run this test, e.g.
+// "clickhouse://localhost:9000?debug=false".
+func TestConformance(t *testing.T) {
+	uri := os.Getenv("BRO_PDNS_TEST_CLICKHOUSE_URI")
+	if uri == "" {
+		t.Skip("BRO_PDNS_TEST_CLICKHOUSE_URI not set, skipping")
+	}
+
+	store.RunConformance(t, func() store.Store {
+		s, err := New(uri)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		store.MigrateUp(t, s)
+		if err := s.Init(); err != nil {
+			t.Fatalf("Init: %v", err)
+		}
+		return s
+	})
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+// MigrateUp applies every pending migration to s, failing the test on
+// error. It's a convenience for backend tests to bring a fresh database up
+// to the current schema before calling Init.
+func MigrateUp(t *testing.T, s Store) {
+	t.Helper()
+	m, ok := s.(Migrator)
+	if !ok {
+		t.Fatalf("%T does not implement Migrator", s)
+	}
+	pending, err := m.PendingMigrations()
+	if err != nil {
+		t.Fatalf("PendingMigrations: %v", err)
+	}
+	for _, mig := range pending {
+		if err := m.ApplyMigration(mig, false); err != nil {
+			t.Fatalf("ApplyMigration %d: %v", mig.Version, err)
+		}
+	}
+}
+
+// RunConformance exercises a Store implementation against the behavior
+// every backend is expected to provide, so new backends can be dropped into
+// the registry with confidence they'll behave like the others. newStore
+// must return a freshly Init'd, empty Store; RunConformance calls Close on
+// it before returning.
+func RunConformance(t *testing.T, newStore func() Store) {
+	t.Helper()
+	s := newStore()
+	defer s.Close()
+
+	if err := s.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	ar := AggregationResult{
+		Tuples: []TupleRecord{
+			{Query: "www.example.com", Type: "A", Answer: "1.2.3.4", TTL: 300, First: now, Last: now, Count: 1, Sensor: "sensor-a"},
+		},
+		Individual: []IndividualRecord{
+			{Which: "Q", Value: "www.example.com", First: now, Last: now, Count: 1, Sensor: "sensor-a"},
+			{Which: "A", Value: "1.2.3.4", First: now, Last: now, Count: 1, Sensor: "sensor-a"},
+		},
+		TotalRecords: 1,
+	}
+
+	if _, err := s.Update(ar); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	t.Run("FindTuples by query", func(t *testing.T) {
+		tr, err := s.FindTuples("www.example.com", "")
+		if err != nil {
+			t.Fatalf("FindTuples: %v", err)
+		}
+		assertOneTuple(t, tr)
+	})
+
+	t.Run("FindTuples by answer", func(t *testing.T) {
+		tr, err := s.FindTuples("1.2.3.4", "")
+		if err != nil {
+			t.Fatalf("FindTuples: %v", err)
+		}
+		assertOneTuple(t, tr)
+	})
+
+	t.Run("FindTuples filtered by sensor", func(t *testing.T) {
+		tr, err := s.FindTuples("www.example.com", "sensor-a")
+		if err != nil {
+			t.Fatalf("FindTuples: %v", err)
+		}
+		assertOneTuple(t, tr)
+		if tr[0].Sensor != "sensor-a" {
+			t.Fatalf("expected sensor-a, got %q", tr[0].Sensor)
+		}
+
+		tr, err = s.FindTuples("www.example.com", "sensor-b")
+		if err != nil {
+			t.Fatalf("FindTuples: %v", err)
+		}
+		if len(tr) != 0 {
+			t.Fatalf("expected no tuples for sensor-b, got %+v", tr)
+		}
+	})
+
+	t.Run("LikeTuples by query suffix", func(t *testing.T) {
+		tr, err := s.LikeTuples("example.com", "")
+		if err != nil {
+			t.Fatalf("LikeTuples: %v", err)
+		}
+		assertOneTuple(t, tr)
+	})
+
+	t.Run("FindIndividual query", func(t *testing.T) {
+		ir, err := s.FindIndividual("www.example.com", "")
+		if err != nil {
+			t.Fatalf("FindIndividual: %v", err)
+		}
+		if len(ir) != 1 || ir[0].Value != "www.example.com" {
+			t.Fatalf("FindIndividual: got %+v", ir)
+		}
+	})
+
+	t.Run("FindIndividual answer", func(t *testing.T) {
+		ir, err := s.FindIndividual("1.2.3.4", "")
+		if err != nil {
+			t.Fatalf("FindIndividual: %v", err)
+		}
+		if len(ir) != 1 || ir[0].Value != "1.2.3.4" {
+			t.Fatalf("FindIndividual: got %+v", ir)
+		}
+	})
+
+	t.Run("IsLogIndexed", func(t *testing.T) {
+		indexed, err := s.IsLogIndexed("test.log")
+		if err != nil {
+			t.Fatalf("IsLogIndexed: %v", err)
+		}
+		if indexed {
+			t.Fatalf("IsLogIndexed: expected false before SetLogIndexed")
+		}
+		if err := s.SetLogIndexed("test.log", ar, UpdateResult{}); err != nil {
+			t.Fatalf("SetLogIndexed: %v", err)
+		}
+		indexed, err = s.IsLogIndexed("test.log")
+		if err != nil {
+			t.Fatalf("IsLogIndexed: %v", err)
+		}
+		if !indexed {
+			t.Fatalf("IsLogIndexed: expected true after SetLogIndexed")
+		}
+	})
+
+	// sensor is part of a tuple's identity alongside query/type/answer,
+	// so the same tuple seen by a second
+	// sensor is stored as a distinct row rather than merged into the
+	// first. Run last: it adds a sensor-b row for the already-seeded
+	// tuple that the earlier subtests above don't expect to see.
+	t.Run("same tuple from a second sensor is a distinct row", func(t *testing.T) {
+		ar2 := AggregationResult{
+			Tuples: []TupleRecord{
+				{Query: "www.example.com", Type: "A", Answer: "1.2.3.4", TTL: 300, First: now, Last: now, Count: 1, Sensor: "sensor-b"},
+			},
+		}
+		if _, err := s.Update(ar2); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		tr, err := s.FindTuples("www.example.com", "")
+		if err != nil {
+			t.Fatalf("FindTuples: %v", err)
+		}
+		if len(tr) != 2 {
+			t.Fatalf("expected 2 tuples (one per sensor), got %d: %+v", len(tr), tr)
+		}
+
+		trA, err := s.FindTuples("www.example.com", "sensor-a")
+		if err != nil {
+			t.Fatalf("FindTuples: %v", err)
+		}
+		assertOneTuple(t, trA)
+
+		trB, err := s.FindTuples("www.example.com", "sensor-b")
+		if err != nil {
+			t.Fatalf("FindTuples: %v", err)
+		}
+		assertOneTuple(t, trB)
+	})
+}
+
+func assertOneTuple(t *testing.T, tr TupleResults) {
+	t.Helper()
+	if len(tr) != 1 {
+		t.Fatalf("expected 1 tuple, got %d: %+v", len(tr), tr)
+	}
+	if tr[0].Query != "www.example.com" || tr[0].Answer != "1.2.3.4" {
+		t.Fatalf("unexpected tuple: %+v", tr[0])
+	}
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+// Migration is a single versioned schema change, keyed by URI scheme since
+// each backend's schema is written in its own SQL dialect. Up and Down hold
+// the ordered statements to run for every backend that has registered SQL
+// for this version.
+type Migration struct {
+	Version int
+	Name    string
+	Up      map[string][]string
+	Down    map[string][]string
+}
+
+var (
+	migrationsMu sync.Mutex
+	migrations   = map[int]*Migration{}
+)
+
+// RegisterMigration adds scheme's Up/Down SQL for the migration at version,
+// merging it into any other backend's SQL already registered for that
+// version. Backend packages call this from init(), alongside Register, once
+// per schema change they need to make.
+func RegisterMigration(version int, name, scheme string, up, down []string) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	m, ok := migrations[version]
+	if !ok {
+		m = &Migration{Version: version, Name: name, Up: map[string][]string{}, Down: map[string][]string{}}
+		migrations[version] = m
+	}
+	m.Up[scheme] = up
+	m.Down[scheme] = down
+}
+
+// Migrations returns every registered migration, sorted by version.
+func Migrations() []Migration {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	out := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out
+}
+
+// PendingMigrations returns, in ascending version order, the migrations
+// that have SQL registered for scheme but aren't present in applied.
+func PendingMigrations(scheme string, applied []int) []Migration {
+	seen := make(map[int]bool, len(applied))
+	for _, v := range applied {
+		seen[v] = true
+	}
+	var pending []Migration
+	for _, m := range Migrations() {
+		if _, ok := m.Up[scheme]; !ok {
+			continue
+		}
+		if !seen[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// Migrator is implemented by stores that support versioned schema
+// migrations via the "bro-pdns migrate" subcommand.
+type Migrator interface {
+	AppliedMigrations() ([]int, error)
+	PendingMigrations() ([]Migration, error)
+	ApplyMigration(m Migration, down bool) error
+}
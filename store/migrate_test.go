@@ -0,0 +1,34 @@
+package store
+
+import "testing"
+
+func TestPendingMigrations(t *testing.T) {
+	RegisterMigration(9001, "test migration", "testscheme", []string{"UP"}, []string{"DOWN"})
+
+	pending := PendingMigrations("testscheme", nil)
+	found := false
+	for _, m := range pending {
+		if m.Version == 9001 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected version 9001 to be pending, got %+v", pending)
+	}
+
+	pending = PendingMigrations("testscheme", []int{9001})
+	for _, m := range pending {
+		if m.Version == 9001 {
+			t.Fatalf("version 9001 should not be pending once applied")
+		}
+	}
+
+	// A scheme with no SQL registered for this version should never see it
+	// as pending.
+	pending = PendingMigrations("no-such-scheme", nil)
+	for _, m := range pending {
+		if m.Version == 9001 {
+			t.Fatalf("version 9001 has no SQL for no-such-scheme, should not be pending")
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package postgres
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bro-pdns/bro-pdns/store"
+)
+
+// TestConformance requires a running Postgres instance; point
+// BRO_PDNS_TEST_POSTGRES_URI at it to run this test. `docker-compose up` in
+// this directory starts one on localhost:5432 with matching credentials.
+func TestConformance(t *testing.T) {
+	uri := os.Getenv("BRO_PDNS_TEST_POSTGRES_URI")
+	if uri == "" {
+		t.Skip("BRO_PDNS_TEST_POSTGRES_URI not set, skipping")
+	}
+
+	store.RunConformance(t, func() store.Store {
+		s, err := New(uri)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		store.MigrateUp(t, s)
+		if err := s.Init(); err != nil {
+			t.Fatalf("Init: %v", err)
+		}
+		return s
+	})
+}
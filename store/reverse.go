@@ -0,0 +1,87 @@
+package store
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// Reverse reverses a domain name by its dot-separated labels, e.g.
+// "www.example.com" becomes "com.example.www". Storing query values in this
+// form lets a plain string-prefix index serve suffix (domain) lookups as
+// well as exact ones.
+func Reverse(name string) string {
+	labels := strings.Split(name, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, ".")
+}
+
+// ReverseTuples reverses the Query field of each result back into normal
+// domain-name order, in place.
+func ReverseTuples(tr TupleResults) {
+	for i := range tr {
+		tr[i].Query = Reverse(tr[i].Query)
+	}
+}
+
+// ReverseIndividual reverses the Value field of each 'Q' (query) result back
+// into normal domain-name order, in place. 'A' (answer) results are left
+// untouched since they're stored in their original order.
+func ReverseIndividual(ir IndividualResults) {
+	for i := range ir {
+		if ir[i].Which == "Q" {
+			ir[i].Value = Reverse(ir[i].Value)
+		}
+	}
+}
+
+// TupleJSONReader streams ar.Tuples as newline-delimited JSON objects, one
+// per record, for bulk loading into a backend. When reverse is true, each
+// record's Query is written in reversed-label order so it stays sortable
+// alongside suffix lookups.
+func (ar AggregationResult) TupleJSONReader(reverse bool) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
+		var err error
+		for _, t := range ar.Tuples {
+			if reverse {
+				t.Query = Reverse(t.Query)
+			}
+			if t.Sensor == "" {
+				t.Sensor = ar.Sensor
+			}
+			if err = enc.Encode(t); err != nil {
+				break
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// IndividualJSONReader streams ar.Individual as newline-delimited JSON
+// objects. When reverse is true, 'Q' records have their Value written in
+// reversed-label order, matching TupleJSONReader's treatment of queries.
+func (ar AggregationResult) IndividualJSONReader(reverse bool) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
+		var err error
+		for _, v := range ar.Individual {
+			if reverse && v.Which == "Q" {
+				v.Value = Reverse(v.Value)
+			}
+			if v.Sensor == "" {
+				v.Sensor = ar.Sensor
+			}
+			if err = enc.Encode(v); err != nil {
+				break
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
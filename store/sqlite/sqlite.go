@@ -0,0 +1,519 @@
+// Package sqlite implements store.Store on top of SQLite, so bro-pdns can
+// run on a single node without standing up ClickHouse or Postgres. It
+// registers itself under the "sqlite" URI scheme.
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+
+	"github.com/bro-pdns/bro-pdns/store"
+)
+
+const scheme = "sqlite"
+
+func init() {
+	store.Register(scheme, New)
+	store.RegisterMigration(1, "initial schema", scheme, schemaV1Up, schemaV1Down)
+	store.RegisterMigration(2, "add sensor column", scheme, schemaV2Up, schemaV2Down)
+}
+
+const migrationsSchema = `CREATE TABLE IF NOT EXISTS migrations (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT NOT NULL,
+	applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+)`
+
+var schemaV1Up = []string{
+	`CREATE TABLE IF NOT EXISTS tuples (
+		query  TEXT NOT NULL,
+		type   TEXT NOT NULL,
+		answer TEXT NOT NULL,
+		ttl    INTEGER NOT NULL,
+		first  DATETIME NOT NULL,
+		last   DATETIME NOT NULL,
+		count  INTEGER NOT NULL,
+		PRIMARY KEY (query, type, answer)
+	)`,
+	`CREATE INDEX IF NOT EXISTS tuples_answer_idx ON tuples (answer)`,
+	`CREATE TABLE IF NOT EXISTS individual (
+		which TEXT NOT NULL,
+		value TEXT NOT NULL,
+		first DATETIME NOT NULL,
+		last  DATETIME NOT NULL,
+		count INTEGER NOT NULL,
+		PRIMARY KEY (which, value)
+	)`,
+	`CREATE TABLE IF NOT EXISTS filenames (
+		filename         TEXT PRIMARY KEY,
+		ts               DATETIME DEFAULT CURRENT_TIMESTAMP,
+		aggregation_time REAL,
+		total_records    INTEGER,
+		skipped_records  INTEGER,
+		tuples           INTEGER,
+		individual       INTEGER,
+		store_time       REAL,
+		inserted         INTEGER,
+		updated          INTEGER
+	)`,
+}
+
+var schemaV1Down = []string{
+	"DROP TABLE IF EXISTS tuples",
+	"DROP TABLE IF EXISTS individual",
+	"DROP TABLE IF EXISTS filenames",
+}
+
+// schemaV2 adds a sensor tag to every tuple/individual observation, so
+// deployments ingesting from many sensors can tell which one saw a given
+// record. sensor joins the primary key, so the same tuple seen by two
+// sensors stores as two rows; SQLite can't alter an existing PRIMARY KEY in
+// place, so this recreates both tables.
+var schemaV2Up = []string{
+	"ALTER TABLE tuples RENAME TO tuples_v1",
+	`CREATE TABLE tuples (
+		query  TEXT NOT NULL,
+		type   TEXT NOT NULL,
+		answer TEXT NOT NULL,
+		sensor TEXT NOT NULL DEFAULT '',
+		ttl    INTEGER NOT NULL,
+		first  DATETIME NOT NULL,
+		last   DATETIME NOT NULL,
+		count  INTEGER NOT NULL,
+		PRIMARY KEY (query, type, answer, sensor)
+	)`,
+	`INSERT INTO tuples (query, type, answer, sensor, ttl, first, last, count)
+		SELECT query, type, answer, '', ttl, first, last, count FROM tuples_v1`,
+	"DROP TABLE tuples_v1",
+	"CREATE INDEX IF NOT EXISTS tuples_answer_idx ON tuples (answer)",
+
+	"ALTER TABLE individual RENAME TO individual_v1",
+	`CREATE TABLE individual (
+		which  TEXT NOT NULL,
+		value  TEXT NOT NULL,
+		sensor TEXT NOT NULL DEFAULT '',
+		first  DATETIME NOT NULL,
+		last   DATETIME NOT NULL,
+		count  INTEGER NOT NULL,
+		PRIMARY KEY (which, value, sensor)
+	)`,
+	`INSERT INTO individual (which, value, sensor, first, last, count)
+		SELECT which, value, '', first, last, count FROM individual_v1`,
+	"DROP TABLE individual_v1",
+}
+
+var schemaV2Down = []string{
+	"ALTER TABLE tuples RENAME TO tuples_v2",
+	`CREATE TABLE tuples (
+		query  TEXT NOT NULL,
+		type   TEXT NOT NULL,
+		answer TEXT NOT NULL,
+		ttl    INTEGER NOT NULL,
+		first  DATETIME NOT NULL,
+		last   DATETIME NOT NULL,
+		count  INTEGER NOT NULL,
+		PRIMARY KEY (query, type, answer)
+	)`,
+	`INSERT OR REPLACE INTO tuples (query, type, answer, ttl, first, last, count)
+		SELECT query, type, answer, ttl, first, last, count FROM tuples_v2`,
+	"DROP TABLE tuples_v2",
+	"CREATE INDEX IF NOT EXISTS tuples_answer_idx ON tuples (answer)",
+
+	"ALTER TABLE individual RENAME TO individual_v2",
+	`CREATE TABLE individual (
+		which TEXT NOT NULL,
+		value TEXT NOT NULL,
+		first DATETIME NOT NULL,
+		last  DATETIME NOT NULL,
+		count INTEGER NOT NULL,
+		PRIMARY KEY (which, value)
+	)`,
+	`INSERT OR REPLACE INTO individual (which, value, first, last, count)
+		SELECT which, value, first, last, count FROM individual_v2`,
+	"DROP TABLE individual_v2",
+}
+
+// Store is a store.Store backed by SQLite.
+type Store struct {
+	conn *sql.DB
+	tx   *sql.Tx
+}
+
+// New opens a SQLite-backed store.Store for the given URI, e.g.
+// "sqlite:///var/lib/bro-pdns/store.db". The scheme is stripped before
+// handing the path to the sqlite3 driver.
+func New(uri string) (store.Store, error) {
+	path := strings.TrimPrefix(uri, scheme+"://")
+
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		return nil, err
+	}
+	return &Store{conn: conn}, nil
+}
+
+func (s *Store) Close() error {
+	return s.conn.Close()
+}
+
+// Init verifies the schema is up to date. It does not apply schema changes
+// itself: run `bro-pdns migrate up` first if it reports pending migrations.
+func (s *Store) Init() error {
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("sqlite: %d pending migration(s), run `bro-pdns migrate up`", len(pending))
+	}
+	return nil
+}
+
+func (s *Store) ensureMigrationsTable() error {
+	_, err := s.conn.Exec(migrationsSchema)
+	return err
+}
+
+func (s *Store) AppliedMigrations() ([]int, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := s.conn.Query("SELECT version FROM migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var versions []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+func (s *Store) PendingMigrations() ([]store.Migration, error) {
+	applied, err := s.AppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return store.PendingMigrations(scheme, applied), nil
+}
+
+func (s *Store) ApplyMigration(m store.Migration, down bool) error {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return err
+	}
+	stmts := m.Up[scheme]
+	if down {
+		stmts = m.Down[scheme]
+	}
+
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "sqlite: migration %d (%s) failed", m.Version, m.Name)
+		}
+	}
+	if down {
+		_, err = tx.Exec("DELETE FROM migrations WHERE version = ?", m.Version)
+	} else {
+		_, err = tx.Exec("INSERT INTO migrations (version, name) VALUES (?, ?)", m.Version, m.Name)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Clear() error {
+	stmts := []string{"DELETE FROM filenames", "DELETE FROM individual", "DELETE FROM tuples"}
+	for _, stmt := range stmts {
+		if _, err := s.conn.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Begin() error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	s.tx = tx
+	return nil
+}
+
+func (s *Store) Commit() error {
+	if s.tx == nil {
+		return fmt.Errorf("sqlite: Commit called without a Begin")
+	}
+	err := s.tx.Commit()
+	s.tx = nil
+	return err
+}
+
+// DeleteOld deletes records that haven't been seen in days, returns the
+// total records deleted.
+func (s *Store) DeleteOld(days int64) (int64, error) {
+	var total int64
+	stmts := []string{
+		fmt.Sprintf("DELETE FROM tuples WHERE last < datetime('now', '-%d days')", days),
+		fmt.Sprintf("DELETE FROM individual WHERE last < datetime('now', '-%d days')", days),
+	}
+	for _, stmt := range stmts {
+		res, err := s.conn.Exec(stmt)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (s *Store) Update(ar store.AggregationResult) (store.UpdateResult, error) {
+	var result store.UpdateResult
+	start := time.Now()
+
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return result, err
+	}
+
+	upsertTuple, err := tx.Prepare(`
+		INSERT INTO tuples (query, type, answer, sensor, ttl, first, last, count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (query, type, answer, sensor) DO UPDATE SET
+			ttl = excluded.ttl,
+			first = min(first, excluded.first),
+			last = max(last, excluded.last),
+			count = count + excluded.count`)
+	if err != nil {
+		tx.Rollback()
+		return result, errors.Wrap(err, "sqlite: failed to prepare tuple upsert")
+	}
+	defer upsertTuple.Close()
+
+	for _, t := range ar.Tuples {
+		rquery := store.Reverse(t.Query)
+		sensor := t.Sensor
+		if sensor == "" {
+			sensor = ar.Sensor
+		}
+		if _, err := upsertTuple.Exec(rquery, t.Type, t.Answer, sensor, t.TTL, t.First, t.Last, t.Count); err != nil {
+			tx.Rollback()
+			return result, errors.Wrap(err, "sqlite: tuple upsert failed")
+		}
+		result.Inserted++
+	}
+
+	upsertValue, err := tx.Prepare(`
+		INSERT INTO individual (which, value, sensor, first, last, count)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (which, value, sensor) DO UPDATE SET
+			first = min(first, excluded.first),
+			last = max(last, excluded.last),
+			count = count + excluded.count`)
+	if err != nil {
+		tx.Rollback()
+		return result, errors.Wrap(err, "sqlite: failed to prepare individual upsert")
+	}
+	defer upsertValue.Close()
+
+	for _, v := range ar.Individual {
+		value := v.Value
+		if v.Which == "Q" {
+			value = store.Reverse(value)
+		}
+		sensor := v.Sensor
+		if sensor == "" {
+			sensor = ar.Sensor
+		}
+		if _, err := upsertValue.Exec(v.Which, value, sensor, v.First, v.Last, v.Count); err != nil {
+			tx.Rollback()
+			return result, errors.Wrap(err, "sqlite: individual upsert failed")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+func (s *Store) IsLogIndexed(filename string) (bool, error) {
+	var fn string
+	err := s.conn.QueryRow("SELECT filename FROM filenames WHERE filename=?", filename).Scan(&fn)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+func (s *Store) SetLogIndexed(filename string, ar store.AggregationResult, ur store.UpdateResult) error {
+	q := `INSERT INTO filenames (filename,
+	      aggregation_time, total_records, skipped_records, tuples, individual,
+	      store_time, inserted, updated)
+	      VALUES (?,?,?,?,?,?,?,?,?)`
+	_, err := s.conn.Exec(q, filename,
+		ar.Duration.Seconds(), uint64(ar.TotalRecords), uint64(ar.SkippedRecords), len(ar.Tuples), len(ar.Individual),
+		ur.Duration.Seconds(), uint64(ur.Inserted), uint64(ur.Updated))
+	return err
+}
+
+func (s *Store) FindQueryTuples(query, sensor string) (store.TupleResults, error) {
+	tr := store.TupleResults{}
+	rquery := store.Reverse(query)
+	rows, err := s.conn.Query("SELECT query, type, answer, sensor, first, last, count FROM tuples WHERE query = ? AND (? = '' OR sensor = ?)", rquery, sensor, sensor)
+	if err != nil {
+		return tr, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t store.TupleResult
+		if err := rows.Scan(&t.Query, &t.Type, &t.Answer, &t.Sensor, &t.First, &t.Last, &t.Count); err != nil {
+			return tr, err
+		}
+		tr = append(tr, t)
+	}
+	store.ReverseTuples(tr)
+	return tr, rows.Err()
+}
+
+func (s *Store) FindTuples(query, sensor string) (store.TupleResults, error) {
+	return s.findTuples(query, sensor, false)
+}
+
+func (s *Store) LikeTuples(query, sensor string) (store.TupleResults, error) {
+	return s.findTuples(query, sensor, true)
+}
+
+func (s *Store) findTuples(query, sensor string, like bool) (store.TupleResults, error) {
+	tr := store.TupleResults{}
+	rquery := store.Reverse(query)
+	op := "="
+	qArg, aArg := rquery, query
+	if like {
+		op = "LIKE"
+		qArg, aArg = rquery+"%", query+"%"
+	}
+	rows, err := s.conn.Query(fmt.Sprintf(`
+		SELECT query, type, answer, sensor, first, last, count FROM tuples
+		WHERE (query %s ? OR answer %s ?) AND (? = '' OR sensor = ?)
+		ORDER BY query, answer`, op, op), qArg, aArg, sensor, sensor)
+	if err != nil {
+		return tr, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t store.TupleResult
+		if err := rows.Scan(&t.Query, &t.Type, &t.Answer, &t.Sensor, &t.First, &t.Last, &t.Count); err != nil {
+			return tr, err
+		}
+		tr = append(tr, t)
+	}
+	store.ReverseTuples(tr)
+	return tr, rows.Err()
+}
+
+func (s *Store) FindIndividual(value, sensor string) (store.IndividualResults, error) {
+	return s.findIndividual(value, sensor, false)
+}
+
+func (s *Store) LikeIndividual(value, sensor string) (store.IndividualResults, error) {
+	return s.findIndividual(value, sensor, true)
+}
+
+func (s *Store) findIndividual(value, sensor string, like bool) (store.IndividualResults, error) {
+	ir := store.IndividualResults{}
+	rvalue := store.Reverse(value)
+	op := "="
+	aArg, qArg := value, rvalue
+	if like {
+		op = "LIKE"
+		aArg, qArg = value+"%", rvalue+"%"
+	}
+	rows, err := s.conn.Query(fmt.Sprintf(`
+		SELECT which, value, sensor, first, last, count FROM individual
+		WHERE ((which='A' AND value %s ?) OR (which='Q' AND value %s ?)) AND (? = '' OR sensor = ?)
+		ORDER BY value`, op, op), aArg, qArg, sensor, sensor)
+	if err != nil {
+		return ir, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v store.IndividualResult
+		if err := rows.Scan(&v.Which, &v.Value, &v.Sensor, &v.First, &v.Last, &v.Count); err != nil {
+			return ir, err
+		}
+		ir = append(ir, v)
+	}
+	store.ReverseIndividual(ir)
+	return ir, rows.Err()
+}
+
+// Dump reads every stored tuple and individual record back out as an
+// AggregationResult, for migrating data into another backend (see
+// cmd/bro-pdns-storecopy).
+func (s *Store) Dump() (store.AggregationResult, error) {
+	var ar store.AggregationResult
+
+	rows, err := s.conn.Query("SELECT query, type, answer, sensor, ttl, first, last, count FROM tuples")
+	if err != nil {
+		return ar, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t store.TupleRecord
+		if err := rows.Scan(&t.Query, &t.Type, &t.Answer, &t.Sensor, &t.TTL, &t.First, &t.Last, &t.Count); err != nil {
+			return ar, err
+		}
+		t.Query = store.Reverse(t.Query)
+		ar.Tuples = append(ar.Tuples, t)
+	}
+	if err := rows.Err(); err != nil {
+		return ar, err
+	}
+
+	irows, err := s.conn.Query("SELECT which, value, sensor, first, last, count FROM individual")
+	if err != nil {
+		return ar, err
+	}
+	defer irows.Close()
+	for irows.Next() {
+		var v store.IndividualRecord
+		if err := irows.Scan(&v.Which, &v.Value, &v.Sensor, &v.First, &v.Last, &v.Count); err != nil {
+			return ar, err
+		}
+		if v.Which == "Q" {
+			v.Value = store.Reverse(v.Value)
+		}
+		ar.Individual = append(ar.Individual, v)
+	}
+	return ar, irows.Err()
+}
@@ -0,0 +1,21 @@
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/bro-pdns/bro-pdns/store"
+)
+
+func TestConformance(t *testing.T) {
+	store.RunConformance(t, func() store.Store {
+		s, err := New("sqlite://file::memory:?cache=shared")
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		store.MigrateUp(t, s)
+		if err := s.Init(); err != nil {
+			t.Fatalf("Init: %v", err)
+		}
+		return s
+	})
+}
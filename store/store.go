@@ -0,0 +1,184 @@
+// Package store defines the pluggable storage backend interface used by
+// bro-pdns to persist and query aggregated passive-DNS tuple/individual
+// records, plus a database/sql-style driver registry so new backends can be
+// added without touching the main package.
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// AggregationResult is the output of a single aggregation pass over one or
+// more Bro/Zeek log files, ready to be persisted by a Store. Sensor tags
+// every record the pass produces whose own Sensor is empty, e.g. from a
+// --sensor flag or detected from the source file's path.
+type AggregationResult struct {
+	Tuples         []TupleRecord
+	Individual     []IndividualRecord
+	Duration       time.Duration
+	TotalRecords   int64
+	SkippedRecords int64
+	Sensor         string
+}
+
+// TupleRecord is a single query/type/answer observation, prior to
+// storage-side aggregation across observations.
+type TupleRecord struct {
+	Query  string    `json:"query"`
+	Type   string    `json:"type"`
+	Answer string    `json:"answer"`
+	TTL    uint16    `json:"ttl"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+	Count  uint64    `json:"count"`
+	Sensor string    `json:"sensor"`
+}
+
+// IndividualRecord is a single query ('Q') or answer ('A') value observation.
+type IndividualRecord struct {
+	Which  string    `json:"which"`
+	Value  string    `json:"value"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+	Count  uint64    `json:"count"`
+	Sensor string    `json:"sensor"`
+}
+
+// UpdateResult summarizes the effect of a Store.Update call.
+type UpdateResult struct {
+	Duration time.Duration
+	Inserted uint64
+	Updated  uint64
+}
+
+// TupleResult is a query/type/answer row as returned by the Find/Like tuple
+// queries, aggregated across every observation of that tuple.
+type TupleResult struct {
+	Query  string    `db:"query"`
+	Type   string    `db:"type"`
+	Answer string    `db:"answer"`
+	First  time.Time `db:"first"`
+	Last   time.Time `db:"last"`
+	Count  uint64    `db:"count"`
+	Sensor string    `db:"sensor"`
+}
+
+// TupleResults is a slice of TupleResult, matching the *Results naming the
+// rest of the package uses for query return values.
+type TupleResults []TupleResult
+
+// IndividualResult is a query or answer value row as returned by the
+// Find/Like individual queries.
+type IndividualResult struct {
+	Which  string    `db:"which"`
+	Value  string    `db:"value"`
+	First  time.Time `db:"first"`
+	Last   time.Time `db:"last"`
+	Count  uint64    `db:"count"`
+	Sensor string    `db:"sensor"`
+}
+
+// IndividualResults is a slice of IndividualResult.
+type IndividualResults []IndividualResult
+
+// Store is the interface every storage backend (ClickHouse, Postgres,
+// SQLite, ...) must implement. Backends register a Driver under a URI
+// scheme via Register and are looked up by NewStore.
+type Store interface {
+	// Init verifies the backend's schema is up to date, returning an error
+	// if any migrations are pending. It does not create or migrate the
+	// schema itself.
+	Init() error
+	// Clear removes all stored records, for use in tests.
+	Clear() error
+	Close() error
+
+	Begin() error
+	Commit() error
+
+	// DeleteOld deletes records that haven't been seen in the given number
+	// of days, returning the total number of records deleted.
+	DeleteOld(days int64) (int64, error)
+
+	Update(ar AggregationResult) (UpdateResult, error)
+
+	IsLogIndexed(filename string) (bool, error)
+	SetLogIndexed(filename string, ar AggregationResult, ur UpdateResult) error
+
+	// FindQueryTuples, FindTuples, LikeTuples, FindIndividual and
+	// LikeIndividual all take an optional sensor filter: pass "" to match
+	// every sensor.
+	FindQueryTuples(query, sensor string) (TupleResults, error)
+	FindTuples(query, sensor string) (TupleResults, error)
+	LikeTuples(query, sensor string) (TupleResults, error)
+
+	FindIndividual(value, sensor string) (IndividualResults, error)
+	LikeIndividual(value, sensor string) (IndividualResults, error)
+}
+
+// Driver opens a Store for a URI whose scheme the driver was Registered
+// under.
+type Driver func(uri string) (Store, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]Driver{}
+)
+
+// Register makes a storage backend available under the given URI scheme
+// (e.g. "clickhouse", "postgres", "sqlite"). It is meant to be called from a
+// backend package's init function, and panics if the scheme is registered
+// twice.
+func Register(scheme string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("store: Register driver is nil")
+	}
+	if _, dup := drivers[scheme]; dup {
+		panic("store: Register called twice for scheme " + scheme)
+	}
+	drivers[scheme] = driver
+}
+
+// NewStore dispatches to the Driver registered for uri's scheme, e.g.
+// "clickhouse://host:9000" or "sqlite:///var/lib/bro-pdns/store.db". The
+// backend package (store/clickhouse, store/sqlite, ...) must be imported,
+// typically blank, so its init function can Register.
+func NewStore(uri string) (Store, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("store: invalid uri %q: %w", uri, err)
+	}
+
+	driversMu.RLock()
+	driver, ok := drivers[u.Scheme]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("store: no driver registered for scheme %q", u.Scheme)
+	}
+	return driver(uri)
+}
+
+// Dumper is implemented by stores that can export their full contents as a
+// single AggregationResult, for migrating data between backends (see
+// cmd/bro-pdns-storecopy). Not every Store implements it.
+type Dumper interface {
+	Dump() (AggregationResult, error)
+}
+
+// Drivers returns the URI schemes of every registered backend, in no
+// particular order; callers that need a stable order should sort the
+// result themselves.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	schemes := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		schemes = append(schemes, scheme)
+	}
+	return schemes
+}